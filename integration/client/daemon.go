@@ -21,42 +21,458 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"os"
 	"os/exec"
-	"runtime"
 	"strings"
 	"sync"
-	"syscall"
+	"sync/atomic"
 	"time"
 
+	toml "github.com/pelletier/go-toml/v2"
+
 	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/integration/client/supervisor"
 	"github.com/containerd/plugin"
 )
 
+// defaultHammerTimeout is how long RestartGraceful waits for the old daemon
+// process to exit on its own after the new one takes over the listener,
+// before it gives up and sends SIGKILL.
+const defaultHammerTimeout = 10 * time.Second
+
 type daemon struct {
 	sync.Mutex
 	addr string
 	cmd  *exec.Cmd
+	sv   *supervisor.Supervisor
+
+	// runtimeConfigPath is the temp config.toml rendered from Runtimes
+	// for the current d.cmd, if any, removed once that process exits.
+	runtimeConfigPath string
+
+	// HammerTimeout bounds how long RestartGraceful waits for the old
+	// process to drain and exit before it is forcibly killed. Zero means
+	// defaultHammerTimeout.
+	HammerTimeout time.Duration
+
+	// OnShutdownStage, if non-nil, is called as Shutdown progresses
+	// through its escalation stages, so tests can assert which path was
+	// taken.
+	OnShutdownStage func(Stage)
+
+	shutdownCalls int32
+
+	// waitDone/waitCmd/waitErr let concurrent Shutdown calls for the same
+	// process share a single cmd.Wait() and observe the same result,
+	// instead of racing on d.cmd being cleared by whichever of them
+	// happens to reach it first.
+	waitDone chan struct{}
+	waitCmd  *exec.Cmd
+	waitErr  error
+
+	// Runtimes lists the shims waitForStart should require to be
+	// available before a test proceeds, e.g. io.containerd.kata.v2.
+	Runtimes []RuntimeSpec
+}
+
+// RuntimeSpec describes a task runtime v2 shim the daemon is expected to
+// advertise, such as "io.containerd.runc.v2" or "io.containerd.kata.v2".
+type RuntimeSpec struct {
+	// Name is the runtime/shim identifier, e.g. "io.containerd.kata.v2".
+	Name string
+	// Binary is the shim binary's path. If empty, it is derived from
+	// Name (e.g. "io.containerd.kata.v2" -> "containerd-shim-kata-v2")
+	// and looked up on $PATH.
+	Binary string
+	// Options, if non-empty, is rendered as the runtime's options table in
+	// the generated config.toml fragment.
+	Options map[string]interface{}
+}
+
+// shimBinaryName derives the conventional containerd-shim-<kind>-<version>
+// binary name from a runtime identifier like "io.containerd.kata.v2".
+func shimBinaryName(runtimeName string) string {
+	parts := strings.Split(runtimeName, ".")
+	if len(parts) < 2 {
+		return runtimeName
+	}
+	version := parts[len(parts)-1]
+	kind := parts[len(parts)-2]
+	return fmt.Sprintf("containerd-shim-%s-%s", kind, version)
+}
+
+// RenderRuntimeConfig writes a temporary config.toml containing a
+// [plugins."io.containerd.runtime.v2.task"] fragment advertising d.Runtimes,
+// and returns its path. It returns "" if d.Runtimes is empty. start and
+// startWithListener call it and pass the result to the daemon via --config,
+// so integration tests can spin up a
+// containerd that knows about kata/runsc/youki shims without hand-authoring
+// TOML in every test.
+//
+// Like supervisor.Config.renderConfigFile, this marshals through
+// github.com/pelletier/go-toml/v2 rather than formatting TOML by hand, so
+// rt.Options values keep their Go types (bools, ints, ...) instead of all
+// being coerced to strings, and dotted runtime names are quoted correctly.
+func (d *daemon) RenderRuntimeConfig() (string, error) {
+	if len(d.Runtimes) == 0 {
+		return "", nil
+	}
+
+	runtimes := make(map[string]interface{}, len(d.Runtimes))
+	for _, rt := range d.Runtimes {
+		entry := map[string]interface{}{
+			"runtime_type": rt.Name,
+		}
+		if rt.Binary != "" {
+			entry["binary_name"] = rt.Binary
+		}
+		if len(rt.Options) > 0 {
+			entry["options"] = rt.Options
+		}
+		runtimes[rt.Name] = entry
+	}
+
+	doc := struct {
+		Plugins map[string]interface{} `toml:"plugins"`
+	}{
+		Plugins: map[string]interface{}{
+			"io.containerd.runtime.v2.task": map[string]interface{}{
+				"runtimes": runtimes,
+			},
+		},
+	}
+
+	f, err := os.CreateTemp("", "containerd-runtimes-*.toml")
+	if err != nil {
+		return "", fmt.Errorf("failed to create runtime config: %w", err)
+	}
+	defer f.Close()
+	if err := toml.NewEncoder(f).Encode(doc); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write runtime config: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// checkRuntimes verifies that every shim in d.Runtimes has a resolvable
+// binary and, where registered has an entry for the runtime's Name, that
+// containerd reported it loaded successfully. registered maps plugin ID to whether it initialized
+// without error, as observed by waitForStart's introspection call.
+func (d *daemon) checkRuntimes(registered map[string]bool) error {
+	var missing []string
+	for _, rt := range d.Runtimes {
+		path := rt.Binary
+		if path == "" {
+			path = shimBinaryName(rt.Name)
+		}
+		if _, err := exec.LookPath(path); err != nil {
+			missing = append(missing, rt.Name)
+			continue
+		}
+		if ok, known := registered[rt.Name]; known && !ok {
+			missing = append(missing, rt.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return &RuntimeUnavailableError{Missing: missing}
+	}
+	return nil
+}
+
+// Stage identifies a point reached during Shutdown's escalation.
+type Stage int
+
+const (
+	// StageSignaling is reached just before the graceful shutdown signal
+	// is sent to the daemon.
+	StageSignaling Stage = iota
+	// StageWaiting is reached once the signal has been sent and Shutdown
+	// is waiting for the process to exit on its own.
+	StageWaiting
+	// StageEscalating is reached when the context deadline elapses
+	// before the process exited gracefully, just before it is killed.
+	StageEscalating
+	// StageForceKilled is reached when Shutdown skips the graceful wait
+	// entirely because three or more calls overlapped.
+	StageForceKilled
+	// StageExited is reached once the process has exited, however it
+	// got there.
+	StageExited
+)
+
+func (s Stage) String() string {
+	switch s {
+	case StageSignaling:
+		return "signaling"
+	case StageWaiting:
+		return "waiting"
+	case StageEscalating:
+		return "escalating"
+	case StageForceKilled:
+		return "force-killed"
+	case StageExited:
+		return "exited"
+	default:
+		return "unknown"
+	}
+}
+
+func (d *daemon) emitShutdownStage(stage Stage) {
+	if d.OnShutdownStage != nil {
+		d.OnShutdownStage(stage)
+	}
+}
+
+// sharedWaitDone returns a channel that is closed once cmd has exited, and
+// memoizes the result in d.waitErr. Concurrent callers racing to wait on
+// the same process (e.g. overlapping Shutdown calls) share a single
+// cmd.Wait() and all observe the same outcome, instead of all but the
+// first hitting the nil-cmd guard once it has been cleared.
+func (d *daemon) sharedWaitDone(cmd *exec.Cmd) <-chan struct{} {
+	d.Lock()
+	if d.waitDone != nil && d.waitCmd == cmd {
+		done := d.waitDone
+		d.Unlock()
+		return done
+	}
+	done := make(chan struct{})
+	d.waitDone = done
+	d.waitCmd = cmd
+	d.Unlock()
+
+	go func() {
+		err := cmd.Wait()
+		d.Lock()
+		d.waitErr = err
+		if d.cmd == cmd {
+			d.cmd = nil
+			if d.runtimeConfigPath != "" {
+				os.Remove(d.runtimeConfigPath)
+				d.runtimeConfigPath = ""
+			}
+		}
+		d.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+// Shutdown stops the daemon the way dockerd does: send a graceful shutdown
+// signal and wait for it to exit until ctx is done, then escalate to Kill.
+// If three or more calls to Shutdown are in flight at once, the graceful
+// wait is skipped entirely and the process is killed immediately, mirroring
+// the "three interrupts forces exit" behavior of interactive CLIs.
+func (d *daemon) Shutdown(ctx context.Context) error {
+	calls := atomic.AddInt32(&d.shutdownCalls, 1)
+	defer atomic.AddInt32(&d.shutdownCalls, -1)
+
+	d.Lock()
+	cmd := d.cmd
+	d.Unlock()
+	if cmd == nil {
+		return ErrDaemonNotRunning
+	}
+
+	if calls >= 3 {
+		d.emitShutdownStage(StageForceKilled)
+		cmd.Process.Kill()
+		<-d.sharedWaitDone(cmd)
+		d.Lock()
+		err := d.waitErr
+		d.Unlock()
+		d.emitShutdownStage(StageExited)
+		return err
+	}
+
+	d.emitShutdownStage(StageSignaling)
+	if err := shutdownSignal(cmd.Process); err != nil {
+		return fmt.Errorf("failed to signal daemon: %w", err)
+	}
+
+	d.emitShutdownStage(StageWaiting)
+	done := d.sharedWaitDone(cmd)
+
+	select {
+	case <-done:
+		d.Lock()
+		err := d.waitErr
+		d.Unlock()
+		d.emitShutdownStage(StageExited)
+		return err
+	case <-ctx.Done():
+		d.emitShutdownStage(StageEscalating)
+		cmd.Process.Kill()
+		<-done
+		d.Lock()
+		err := d.waitErr
+		d.Unlock()
+		d.emitShutdownStage(StageExited)
+		return err
+	}
+}
+
+// StartSupervised is a thin wrapper around supervisor.Supervisor.Start: it
+// hands the daemon off to a Supervisor that keeps it alive across crashes
+// according to cfg.RestartPolicy, instead of the single exec.Cmd that
+// start/Wait/Restart manage directly. Tests that need to survive and
+// observe spurious daemon crashes should use this instead of start.
+func (d *daemon) StartSupervised(ctx context.Context, cfg supervisor.Config) (<-chan supervisor.Event, error) {
+	d.Lock()
+	if d.sv != nil {
+		d.Unlock()
+		return nil, fmt.Errorf("%w under supervision", ErrDaemonAlreadyRunning)
+	}
+	d.sv = &supervisor.Supervisor{}
+	d.addr = cfg.Address
+	sv := d.sv
+	d.Unlock()
+
+	return sv.Start(ctx, cfg)
 }
 
 func (d *daemon) start(name, address string, args []string, stdout, stderr io.Writer) error {
 	d.Lock()
 	defer d.Unlock()
 	if d.cmd != nil {
-		return errors.New("daemon is already running")
+		return ErrDaemonAlreadyRunning
 	}
+
+	configPath, err := d.RenderRuntimeConfig()
+	if err != nil {
+		return err
+	}
+
 	args = append(args, []string{"--address", address}...)
+	if configPath != "" {
+		args = append(args, "--config", configPath)
+	}
 	cmd := exec.Command(name, args...)
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		cmd.Wait()
+		if configPath != "" {
+			os.Remove(configPath)
+		}
+		return fmt.Errorf("failed to start daemon: %w", err)
+	}
+	d.addr = address
+	d.cmd = cmd
+	d.runtimeConfigPath = configPath
+	return nil
+}
+
+// startWithListener is like start, but the unix socket is bound by the
+// caller ahead of time and handed to the child over LISTEN_FDS, following
+// the systemd socket-activation protocol (LISTEN_FDS/LISTEN_PID/LISTEN_FDNAMES).
+// This lets a new daemon instance begin accepting connections on the same
+// address before the previous instance has stopped listening, so in-flight
+// streaming RPCs on the old instance are not dropped by a rebind race.
+//
+// Socket activation depends on fd-passing and /bin/sh, so this only works
+// for unix socket addresses; address must not be a Windows named pipe.
+//
+// replacing, if non-nil, is the process currently occupying d.cmd that this
+// call is meant to take over from - RestartGraceful's in-flight old daemon,
+// still draining while the replacement comes up. Without it, the normal
+// "already running" guard would always reject starting the replacement
+// before the old process has been asked to stop.
+func (d *daemon) startWithListener(name, address string, args []string, stdout, stderr io.Writer, replacing *exec.Cmd) error {
+	d.Lock()
+	defer d.Unlock()
+	if d.cmd != nil && d.cmd != replacing {
+		return ErrDaemonAlreadyRunning
+	}
+	if isNamedPipeAddress(address) {
+		return errors.New("startWithListener does not support Windows named pipes; RestartGraceful requires a unix socket address")
+	}
+
+	listener, err := newActivationListener(address)
+	if err != nil {
+		return fmt.Errorf("failed to bind activation listener: %w", err)
+	}
+	defer listener.Close()
+
+	listenerFile, err := listener.File()
+	if err != nil {
+		return fmt.Errorf("failed to dup activation listener: %w", err)
+	}
+	defer listenerFile.Close()
+
+	configPath, err := d.RenderRuntimeConfig()
+	if err != nil {
+		return err
+	}
+
+	args = append(args, []string{"--address", address}...)
+	if configPath != "" {
+		args = append(args, "--config", configPath)
+	}
+
+	// LISTEN_PID must equal the child's own pid, which is not known until
+	// after fork. We let the child set it on itself, between fork and
+	// exec, by delegating the final exec to a shell: `exec` in a child
+	// shell replaces the process image in place, preserving file
+	// descriptors (including the inherited activation socket at fd 3),
+	// so $$ still resolves to the real daemon pid.
+	shellArgs := append([]string{name}, args...)
+	cmd := exec.Command("/bin/sh", "-c", `LISTEN_PID=$$ exec "$@"`, "--", shellArgs...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.ExtraFiles = []*os.File{listenerFile}
+	cmd.Env = append(os.Environ(),
+		"LISTEN_FDS=1",
+		"LISTEN_FDNAMES=containerd.sock",
+	)
+	setProcessGroup(cmd)
+
 	if err := cmd.Start(); err != nil {
 		cmd.Wait()
+		if configPath != "" {
+			os.Remove(configPath)
+		}
 		return fmt.Errorf("failed to start daemon: %w", err)
 	}
 	d.addr = address
 	d.cmd = cmd
+	d.runtimeConfigPath = configPath
 	return nil
 }
 
+// newActivationListener binds a unix socket listener for address, removing
+// any stale socket file left behind by a previous run.
+func newActivationListener(address string) (*net.UnixListener, error) {
+	addr := strings.TrimPrefix(address, "unix://")
+	if err := os.RemoveAll(addr); err != nil {
+		return nil, fmt.Errorf("failed to remove existing socket %s: %w", addr, err)
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: addr, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return l, nil
+}
+
+// isNamedPipeAddress reports whether addr refers to a Windows named pipe,
+// as opposed to a unix socket.
+func isNamedPipeAddress(addr string) bool {
+	return strings.HasPrefix(addr, "npipe://") || strings.HasPrefix(addr, `\\.\pipe\`)
+}
+
+// Address returns the canonical form of the daemon's listen address -
+// scheme prefixes such as "unix://" or "npipe://" stripped - so tests can
+// construct clients uniformly across unix sockets and Windows named pipes.
+func (d *daemon) Address() string {
+	d.Lock()
+	defer d.Unlock()
+	if isNamedPipeAddress(d.addr) {
+		return canonicalPipeAddress(d.addr)
+	}
+	return strings.TrimPrefix(d.addr, "unix://")
+}
+
 func (d *daemon) waitForStart(ctx context.Context) (*client.Client, error) {
 	var (
 		clientInstance *client.Client
@@ -69,6 +485,12 @@ func (d *daemon) waitForStart(ctx context.Context) (*client.Client, error) {
 	for {
 		select {
 		case <-ticker.C:
+			if isNamedPipeAddress(d.addr) {
+				if perr := probePipe(ctx, d.addr); perr != nil {
+					err = perr
+					continue
+				}
+			}
 			clientInstance, err = client.New(d.addr)
 			if err != nil {
 				continue
@@ -85,50 +507,71 @@ func (d *daemon) waitForStart(ctx context.Context) (*client.Client, error) {
 			if perr != nil {
 				return nil, fmt.Errorf("failed to get plugin list: %w", perr)
 			}
-			var loadErr error
+			var loadErrs PluginLoadErrors
+			registered := make(map[string]bool, len(resp.Plugins))
 			for _, p := range resp.Plugins {
 				if p.InitErr != nil && !strings.Contains(p.InitErr.Message, plugin.ErrSkipPlugin.Error()) {
-					pluginErr := fmt.Errorf("failed to load %s.%s: %s", p.Type, p.ID, p.InitErr.Message)
-					loadErr = errors.Join(loadErr, pluginErr)
+					loadErrs = append(loadErrs, PluginLoadError{Type: p.Type, ID: p.ID, Message: p.InitErr.Message})
 				}
+				registered[p.ID] = p.InitErr == nil
 			}
-			if loadErr != nil {
-				return nil, loadErr
+			if len(loadErrs) > 0 {
+				return nil, loadErrs
+			}
+			if len(d.Runtimes) > 0 {
+				if err := d.checkRuntimes(registered); err != nil {
+					return nil, err
+				}
 			}
 
 			return clientInstance, err
 		case <-ctx.Done():
-			return nil, fmt.Errorf("context deadline exceeded: %w", err)
+			return nil, fmt.Errorf("%w: %v", ErrDaemonNotReady, err)
 		}
 	}
 }
 
+// Stop sends a single graceful shutdown signal and returns immediately,
+// without waiting for the process to exit or escalating. Kept as a thin
+// wrapper for callers that already do their own waiting; new code that
+// wants escalation and the interrupt-count force-kill behavior should use
+// Shutdown instead.
 func (d *daemon) Stop() error {
 	d.Lock()
 	defer d.Unlock()
 	if d.cmd == nil {
-		return errors.New("daemon is not running")
+		return ErrDaemonNotRunning
 	}
-	return d.cmd.Process.Signal(syscall.SIGTERM)
+	return shutdownSignal(d.cmd.Process)
 }
 
+// Kill sends a single forceful kill and returns immediately. Kept as a thin
+// wrapper for backwards compatibility; see Shutdown for escalation.
 func (d *daemon) Kill() error {
 	d.Lock()
 	defer d.Unlock()
 	if d.cmd == nil {
-		return errors.New("daemon is not running")
+		return ErrDaemonNotRunning
 	}
 	return d.cmd.Process.Kill()
 }
 
+// Wait blocks until the daemon exits. It shares its cmd.Wait() call with
+// any concurrent Shutdown, so both observe the same result instead of
+// racing to clear d.cmd or calling cmd.Wait() more than once.
 func (d *daemon) Wait() error {
 	d.Lock()
-	defer d.Unlock()
-	if d.cmd == nil {
-		return errors.New("daemon is not running")
+	cmd := d.cmd
+	d.Unlock()
+	if cmd == nil {
+		return ErrDaemonNotRunning
 	}
-	err := d.cmd.Wait()
-	d.cmd = nil
+
+	<-d.sharedWaitDone(cmd)
+
+	d.Lock()
+	err := d.waitErr
+	d.Unlock()
 	return err
 }
 
@@ -136,15 +579,10 @@ func (d *daemon) Restart(stopCb func()) error {
 	d.Lock()
 	defer d.Unlock()
 	if d.cmd == nil {
-		return errors.New("daemon is not running")
+		return ErrDaemonNotRunning
 	}
 
-	signal := syscall.SIGTERM
-	if runtime.GOOS == "windows" {
-		signal = syscall.SIGKILL
-	}
-	var err error
-	if err = d.cmd.Process.Signal(signal); err != nil {
+	if err := shutdownSignal(d.cmd.Process); err != nil {
 		return fmt.Errorf("failed to signal daemon: %w", err)
 	}
 
@@ -165,3 +603,75 @@ func (d *daemon) Restart(stopCb func()) error {
 
 	return nil
 }
+
+// RestartGraceful replaces the running daemon with a freshly started
+// instance without ever closing the listening socket: the new process is
+// started first, inheriting the bound socket via LISTEN_FDS, and is given a
+// chance to become ready before the old process is asked to drain and
+// exit. This avoids the connection drop and rebind race that Restart has,
+// so tests can exercise upgrade/restart behavior while streaming RPCs
+// (Events, Exec) are in flight.
+//
+// Like startWithListener, this only supports unix socket addresses; it
+// returns an error if the daemon's address is a Windows named pipe.
+//
+// stopCb, if non-nil, is invoked after the old process has been asked to
+// stop but before RestartGraceful waits for it to exit, mirroring Restart's
+// stopCb hook.
+func (d *daemon) RestartGraceful(ctx context.Context, stopCb func()) (*client.Client, error) {
+	d.Lock()
+	oldCmd := d.cmd
+	oldConfigPath := d.runtimeConfigPath
+	addr := d.addr
+	name := ""
+	var args []string
+	if oldCmd != nil {
+		name = oldCmd.Path
+		if len(oldCmd.Args) > 1 {
+			args = append([]string{}, oldCmd.Args[1:]...)
+		}
+	}
+	d.Unlock()
+	if oldCmd == nil {
+		return nil, ErrDaemonNotRunning
+	}
+
+	if err := d.startWithListener(name, addr, args, oldCmd.Stdout, oldCmd.Stderr, oldCmd); err != nil {
+		return nil, fmt.Errorf("failed to start replacement daemon: %w", err)
+	}
+
+	newClient, err := d.waitForStart(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("replacement daemon did not become ready: %w", err)
+	}
+
+	if err := shutdownSignal(oldCmd.Process); err != nil {
+		newClient.Close()
+		return nil, fmt.Errorf("failed to signal old daemon: %w", err)
+	}
+
+	if stopCb != nil {
+		stopCb()
+	}
+
+	hammer := d.HammerTimeout
+	if hammer <= 0 {
+		hammer = defaultHammerTimeout
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- oldCmd.Wait() }()
+
+	select {
+	case <-done:
+	case <-time.After(hammer):
+		oldCmd.Process.Kill()
+		<-done
+	}
+
+	if oldConfigPath != "" {
+		os.Remove(oldConfigPath)
+	}
+
+	return newClient, nil
+}
@@ -0,0 +1,188 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package client
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	toml "github.com/pelletier/go-toml/v2"
+)
+
+func TestShimBinaryName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{name: "io.containerd.runc.v2", want: "containerd-shim-runc-v2"},
+		{name: "io.containerd.kata.v2", want: "containerd-shim-kata-v2"},
+		{name: "solo", want: "solo"},
+	} {
+		if got := shimBinaryName(tc.name); got != tc.want {
+			t.Errorf("shimBinaryName(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestIsNamedPipeAddress(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		want bool
+	}{
+		{addr: "/run/containerd/containerd.sock", want: false},
+		{addr: "unix:///run/containerd/containerd.sock", want: false},
+		{addr: "npipe:////./pipe/containerd-containerd", want: true},
+		{addr: `\\.\pipe\containerd-containerd`, want: true},
+	} {
+		if got := isNamedPipeAddress(tc.addr); got != tc.want {
+			t.Errorf("isNamedPipeAddress(%q) = %v, want %v", tc.addr, got, tc.want)
+		}
+	}
+}
+
+func TestDaemonAddress(t *testing.T) {
+	d := &daemon{addr: "unix:///run/containerd/containerd.sock"}
+	if got, want := d.Address(), "/run/containerd/containerd.sock"; got != want {
+		t.Errorf("Address() = %q, want %q", got, want)
+	}
+}
+
+func TestStageString(t *testing.T) {
+	for _, tc := range []struct {
+		stage Stage
+		want  string
+	}{
+		{StageSignaling, "signaling"},
+		{StageWaiting, "waiting"},
+		{StageEscalating, "escalating"},
+		{StageForceKilled, "force-killed"},
+		{StageExited, "exited"},
+		{Stage(99), "unknown"},
+	} {
+		if got := tc.stage.String(); got != tc.want {
+			t.Errorf("Stage(%d).String() = %q, want %q", tc.stage, got, tc.want)
+		}
+	}
+}
+
+func TestRenderRuntimeConfigQuotesDottedNames(t *testing.T) {
+	d := &daemon{Runtimes: []RuntimeSpec{
+		{
+			Name:   "io.containerd.kata.v2",
+			Binary: "containerd-shim-kata-v2",
+			Options: map[string]interface{}{
+				"ConfigPath": "/etc/kata/config.toml",
+				"DebugMode":  true,
+				"IoUring":    1,
+			},
+		},
+	}}
+
+	path, err := d.RenderRuntimeConfig()
+	if err != nil {
+		t.Fatalf("RenderRuntimeConfig: %v", err)
+	}
+	defer os.Remove(path)
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading rendered config: %v", err)
+	}
+
+	var doc struct {
+		Plugins struct {
+			Task struct {
+				Runtimes map[string]struct {
+					RuntimeType string                 `toml:"runtime_type"`
+					BinaryName  string                 `toml:"binary_name"`
+					Options     map[string]interface{} `toml:"options"`
+				} `toml:"runtimes"`
+			} `toml:"io.containerd.runtime.v2.task"`
+		} `toml:"plugins"`
+	}
+	if err := toml.Unmarshal(content, &doc); err != nil {
+		t.Fatalf("rendered config %q does not parse as TOML: %v", content, err)
+	}
+
+	// A dotted runtime name that was emitted unquoted would be misparsed as
+	// nested tables, so it would not round-trip as a single "runtimes" key.
+	rt, ok := doc.Plugins.Task.Runtimes["io.containerd.kata.v2"]
+	if !ok {
+		t.Fatalf("rendered config %q does not key the runtime by its full dotted name", content)
+	}
+	if rt.RuntimeType != "io.containerd.kata.v2" {
+		t.Errorf("runtime_type = %q, want %q", rt.RuntimeType, "io.containerd.kata.v2")
+	}
+	if rt.BinaryName != "containerd-shim-kata-v2" {
+		t.Errorf("binary_name = %q, want %q", rt.BinaryName, "containerd-shim-kata-v2")
+	}
+	// Option values must keep their Go types, not be coerced to strings.
+	if v, ok := rt.Options["DebugMode"].(bool); !ok || !v {
+		t.Errorf("options.DebugMode = %#v, want bool true", rt.Options["DebugMode"])
+	}
+	if v, ok := rt.Options["IoUring"].(int64); !ok || v != 1 {
+		t.Errorf("options.IoUring = %#v, want int64 1", rt.Options["IoUring"])
+	}
+}
+
+// TestStartWithListenerReplacesRunningDaemon exercises the handoff at the
+// heart of RestartGraceful: starting the replacement process while the old
+// one (passed as replacing) is still d.cmd must succeed and take over the
+// daemon slot, instead of tripping the "already running" guard meant for an
+// unrelated concurrent start.
+func TestStartWithListenerReplacesRunningDaemon(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("startWithListener is unix-only")
+	}
+
+	oldCmd := exec.Command("sleep", "5")
+	if err := oldCmd.Start(); err != nil {
+		t.Fatalf("failed to start fake old daemon: %v", err)
+	}
+	defer oldCmd.Process.Kill()
+
+	d := &daemon{cmd: oldCmd}
+	addr := "unix://" + filepath.Join(t.TempDir(), "containerd.sock")
+
+	if err := d.startWithListener("true", addr, nil, nil, nil, nil); err == nil || err != ErrDaemonAlreadyRunning {
+		t.Fatalf("startWithListener without a replacing value = %v, want %v", err, ErrDaemonAlreadyRunning)
+	}
+
+	if err := d.startWithListener("sleep", addr, []string{"5"}, nil, nil, oldCmd); err != nil {
+		t.Fatalf("startWithListener replacing the running daemon: %v", err)
+	}
+	defer d.cmd.Process.Kill()
+
+	if d.cmd == oldCmd {
+		t.Fatal("startWithListener did not replace d.cmd with the new process")
+	}
+}
+
+func TestRenderRuntimeConfigEmpty(t *testing.T) {
+	d := &daemon{}
+	path, err := d.RenderRuntimeConfig()
+	if err != nil {
+		t.Fatalf("RenderRuntimeConfig: %v", err)
+	}
+	if path != "" {
+		t.Errorf("RenderRuntimeConfig with no Runtimes returned path %q, want empty", path)
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,52 @@
+//go:build !windows
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// setProcessGroup is a no-op on unix-like systems: shutdownSignal here
+// signals the process directly rather than relying on console process
+// groups, so no special SysProcAttr is needed.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// probePipe is only meaningful on Windows; on unix-like systems the daemon
+// is always reached over a unix socket, which waitForStart dials directly
+// through the grpc client.
+func probePipe(ctx context.Context, addr string) error {
+	return fmt.Errorf("named pipe transport is not supported on %s", runtime.GOOS)
+}
+
+// canonicalPipeAddress is a no-op on unix-like systems.
+func canonicalPipeAddress(addr string) string {
+	return addr
+}
+
+// shutdownSignal asks proc to terminate the way the rest of this package
+// expects: a single SIGTERM, which containerd handles as a graceful
+// shutdown request.
+func shutdownSignal(proc *os.Process) error {
+	return proc.Signal(syscall.SIGTERM)
+}
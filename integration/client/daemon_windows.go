@@ -0,0 +1,71 @@
+//go:build windows
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	winio "github.com/Microsoft/go-winio"
+	"golang.org/x/sys/windows"
+)
+
+// setProcessGroup puts cmd in its own process group so that
+// GenerateConsoleCtrlEvent in shutdownSignal can target just this daemon,
+// not every process sharing the caller's console - including the test
+// binary itself.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= windows.CREATE_NEW_PROCESS_GROUP
+}
+
+// probePipe dials addr, a \\.\pipe\... or npipe:// address, to confirm the
+// daemon's named pipe transport is accepting connections before
+// waitForStart attempts a full grpc handshake through the client package,
+// which does not dial npipe addresses itself.
+func probePipe(ctx context.Context, addr string) error {
+	conn, err := winio.DialPipeContext(ctx, canonicalPipeAddress(addr))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// canonicalPipeAddress strips the npipe:// scheme winio does not expect.
+func canonicalPipeAddress(addr string) string {
+	return strings.TrimPrefix(addr, "npipe://")
+}
+
+// shutdownSignal asks proc to terminate the way containerd expects on
+// Windows: SIGTERM is not deliverable to an arbitrary process, so send
+// CTRL_BREAK_EVENT to the child's process group (see setProcessGroup)
+// instead, and kill the process outright if that fails. Callers that need
+// a hard deadline should fall back to proc.Kill if the process does not
+// exit in time.
+func shutdownSignal(proc *os.Process) error {
+	if err := windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(proc.Pid)); err != nil {
+		return proc.Kill()
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrDaemonAlreadyRunning is returned by start/StartSupervised when
+	// the daemon struct already has a process running.
+	ErrDaemonAlreadyRunning = errors.New("daemon is already running")
+	// ErrDaemonNotRunning is returned by Stop, Kill, Wait, Restart,
+	// RestartGraceful and Shutdown when there is no process to act on.
+	ErrDaemonNotRunning = errors.New("daemon is not running")
+	// ErrDaemonNotReady is returned by waitForStart when ctx is done
+	// before the daemon's socket ever came up and started serving.
+	ErrDaemonNotReady = errors.New("daemon did not become ready in time")
+)
+
+// PluginLoadError records that a single plugin failed to initialize while
+// the daemon otherwise came up and started serving.
+type PluginLoadError struct {
+	Type    string
+	ID      string
+	Message string
+}
+
+func (e PluginLoadError) Error() string {
+	return fmt.Sprintf("failed to load %s.%s: %s", e.Type, e.ID, e.Message)
+}
+
+// PluginLoadErrors collects the PluginLoadErrors observed for a single
+// daemon start, so callers can use errors.As to distinguish "the socket
+// came up but plugin X failed" from ErrDaemonNotReady, and can whitelist
+// specific expected failures via Get instead of substring-matching
+// messages.
+type PluginLoadErrors []PluginLoadError
+
+func (e PluginLoadErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msg := fmt.Sprintf("%d plugins failed to load:", len(e))
+	for _, pe := range e {
+		msg += "\n  " + pe.Error()
+	}
+	return msg
+}
+
+// Unwrap allows errors.As/errors.Is to reach each individual
+// PluginLoadError.
+func (e PluginLoadErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, pe := range e {
+		errs[i] = pe
+	}
+	return errs
+}
+
+// Get returns the PluginLoadError for the given plugin type and ID, if one
+// is present.
+func (e PluginLoadErrors) Get(typ, id string) (PluginLoadError, bool) {
+	for _, pe := range e {
+		if pe.Type == typ && pe.ID == id {
+			return pe, true
+		}
+	}
+	return PluginLoadError{}, false
+}
+
+// RuntimeUnavailableError is returned by waitForStart when one or more of
+// the daemon's configured Runtimes has no resolvable shim binary, or
+// failed to register as a runtime plugin.
+type RuntimeUnavailableError struct {
+	// Missing lists the runtime identifiers that were requested but are
+	// not available, e.g. "io.containerd.kata.v2".
+	Missing []string
+}
+
+func (e *RuntimeUnavailableError) Error() string {
+	return fmt.Sprintf("runtime shims not available: %s", strings.Join(e.Missing, ", "))
+}
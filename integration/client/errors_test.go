@@ -0,0 +1,65 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package client
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPluginLoadErrorsGet(t *testing.T) {
+	errs := PluginLoadErrors{
+		{Type: "io.containerd.grpc.v1", ID: "cri", Message: "boom"},
+		{Type: "io.containerd.service.v1", ID: "diff", Message: "nope"},
+	}
+
+	if pe, ok := errs.Get("io.containerd.grpc.v1", "cri"); !ok || pe.Message != "boom" {
+		t.Fatalf("Get returned %+v, %v; want the cri plugin error", pe, ok)
+	}
+	if _, ok := errs.Get("io.containerd.grpc.v1", "missing"); ok {
+		t.Fatal("Get found a plugin error that was never recorded")
+	}
+}
+
+func TestPluginLoadErrorsUnwrap(t *testing.T) {
+	target := PluginLoadError{Type: "io.containerd.grpc.v1", ID: "cri", Message: "boom"}
+	errs := PluginLoadErrors{target, {Type: "t", ID: "other", Message: "x"}}
+
+	if !errors.Is(errs, target) {
+		t.Fatal("errors.Is did not find the wrapped PluginLoadError via Unwrap")
+	}
+}
+
+func TestErrorSentinelsDistinct(t *testing.T) {
+	for _, pair := range [][2]error{
+		{ErrDaemonAlreadyRunning, ErrDaemonNotRunning},
+		{ErrDaemonNotRunning, ErrDaemonNotReady},
+		{ErrDaemonNotReady, ErrDaemonAlreadyRunning},
+	} {
+		if errors.Is(pair[0], pair[1]) {
+			t.Fatalf("%v should not satisfy errors.Is(%v)", pair[0], pair[1])
+		}
+	}
+}
+
+func TestRuntimeUnavailableError(t *testing.T) {
+	err := &RuntimeUnavailableError{Missing: []string{"io.containerd.kata.v2", "io.containerd.runsc.v2"}}
+	const want = "runtime shims not available: io.containerd.kata.v2, io.containerd.runsc.v2"
+	if err.Error() != want {
+		t.Fatalf("Error() = %q, want %q", err.Error(), want)
+	}
+}
@@ -0,0 +1,313 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package supervisor runs a containerd binary for integration tests and
+// keeps it alive across crashes, following the containerd-supervisor split
+// of old: a thin process manager that the test daemon wrapper in
+// integration/client delegates to from StartSupervised. The wrapper's own
+// start, Wait and Restart do not use it; they still manage their exec.Cmd
+// directly, and Restart still re-execs inline.
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	toml "github.com/pelletier/go-toml/v2"
+)
+
+// RestartMode controls whether a Supervisor re-execs the binary after it exits.
+type RestartMode int
+
+const (
+	// RestartNever never restarts the process; the event channel is
+	// closed once it exits.
+	RestartNever RestartMode = iota
+	// RestartOnFailure restarts the process only when it exits with a
+	// non-zero status, backing off between attempts.
+	RestartOnFailure
+	// RestartAlways restarts the process regardless of its exit status.
+	RestartAlways
+)
+
+// Backoff describes the exponential backoff applied between restart
+// attempts. The zero value backs off from 1s, doubling up to 30s.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	Factor  float64
+}
+
+func (b Backoff) forAttempt(attempt int) time.Duration {
+	if b.Initial <= 0 {
+		b.Initial = time.Second
+	}
+	if b.Max <= 0 {
+		b.Max = 30 * time.Second
+	}
+	if b.Factor <= 1 {
+		b.Factor = 2
+	}
+	d := b.Initial
+	for i := 0; i < attempt; i++ {
+		d = time.Duration(float64(d) * b.Factor)
+		if d > b.Max {
+			return b.Max
+		}
+	}
+	return d
+}
+
+// Config describes how to launch and supervise a containerd binary.
+type Config struct {
+	Binary  string
+	Args    []string
+	Address string
+
+	RootDir  string
+	StateDir string
+
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// PluginConfigs is rendered into a temporary config.toml, under a
+	// [plugins.<id>] table per entry, and passed to Binary via --config.
+	PluginConfigs map[string]interface{}
+
+	RestartPolicy RestartMode
+	Backoff       Backoff
+}
+
+func (c *Config) renderConfigFile() (string, error) {
+	if len(c.PluginConfigs) == 0 {
+		return "", nil
+	}
+	f, err := os.CreateTemp("", "containerd-supervisor-*.toml")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp config: %w", err)
+	}
+	defer f.Close()
+
+	doc := struct {
+		Plugins map[string]interface{} `toml:"plugins"`
+	}{Plugins: c.PluginConfigs}
+
+	enc := toml.NewEncoder(f)
+	if err := enc.Encode(doc); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to encode plugin config: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// EventType identifies the kind of Event emitted on a Supervisor's channel.
+type EventType int
+
+const (
+	// EventStarted is emitted each time the process is spawned.
+	EventStarted EventType = iota
+	// EventExited is emitted when the process exits, whether or not it
+	// will be restarted.
+	EventExited
+	// EventRestarting is emitted once RestartPolicy has decided to
+	// re-exec the process, before the backoff sleep.
+	EventRestarting
+	// EventReady is emitted once Config.Address is accepting connections.
+	EventReady
+)
+
+// Event is emitted by a Supervisor as the supervised process's lifecycle
+// progresses.
+type Event struct {
+	Type EventType
+
+	// Err and ExitCode are populated for EventExited.
+	Err      error
+	ExitCode int
+}
+
+// Supervisor runs a containerd binary and, depending on its RestartPolicy,
+// re-execs it across crashes, emitting lifecycle events so long-running
+// integration tests can observe and react to daemon failures instead of
+// blocking forever waiting for a socket that will never come back.
+type Supervisor struct {
+	mu  sync.Mutex
+	cfg Config
+	cmd *exec.Cmd
+}
+
+// Start launches cfg.Binary under supervision and returns a channel of
+// lifecycle events. The channel is closed once the process has exited and
+// RestartPolicy has decided not to restart it again.
+func (s *Supervisor) Start(ctx context.Context, cfg Config) (<-chan Event, error) {
+	s.mu.Lock()
+	if s.cmd != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("supervisor: already running")
+	}
+	s.cfg = cfg
+	s.mu.Unlock()
+
+	configPath, err := cfg.renderConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event, 8)
+	if err := s.spawn(configPath); err != nil {
+		if configPath != "" {
+			os.Remove(configPath)
+		}
+		return nil, err
+	}
+	events <- Event{Type: EventStarted}
+	go s.pollReady(ctx, events)
+
+	go s.monitor(ctx, configPath, events)
+
+	return events, nil
+}
+
+func (s *Supervisor) spawn(configPath string) error {
+	args := append([]string{}, s.cfg.Args...)
+	args = append(args, "--address", s.cfg.Address)
+	if s.cfg.RootDir != "" {
+		args = append(args, "--root", s.cfg.RootDir)
+	}
+	if s.cfg.StateDir != "" {
+		args = append(args, "--state", s.cfg.StateDir)
+	}
+	if configPath != "" {
+		args = append(args, "--config", configPath)
+	}
+
+	cmd := exec.Command(s.cfg.Binary, args...)
+	cmd.Stdout = s.cfg.Stdout
+	cmd.Stderr = s.cfg.Stderr
+	if err := cmd.Start(); err != nil {
+		cmd.Wait()
+		return fmt.Errorf("supervisor: failed to start %s: %w", s.cfg.Binary, err)
+	}
+
+	s.mu.Lock()
+	s.cmd = cmd
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Supervisor) monitor(ctx context.Context, configPath string, events chan<- Event) {
+	defer close(events)
+	if configPath != "" {
+		defer os.Remove(configPath)
+	}
+
+	attempt := 0
+	for {
+		s.mu.Lock()
+		cmd := s.cmd
+		s.mu.Unlock()
+
+		err := cmd.Wait()
+		exitCode := 0
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		}
+		events <- Event{Type: EventExited, Err: err, ExitCode: exitCode}
+
+		restart := false
+		switch s.cfg.RestartPolicy {
+		case RestartAlways:
+			restart = true
+		case RestartOnFailure:
+			restart = err != nil
+		}
+		if ctx.Err() != nil {
+			restart = false
+		}
+		if !restart {
+			s.mu.Lock()
+			s.cmd = nil
+			s.mu.Unlock()
+			return
+		}
+
+		events <- Event{Type: EventRestarting}
+		select {
+		case <-time.After(s.cfg.Backoff.forAttempt(attempt)):
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.cmd = nil
+			s.mu.Unlock()
+			return
+		}
+		attempt++
+
+		if err := s.spawn(configPath); err != nil {
+			events <- Event{Type: EventExited, Err: err, ExitCode: -1}
+			s.mu.Lock()
+			s.cmd = nil
+			s.mu.Unlock()
+			return
+		}
+		events <- Event{Type: EventStarted}
+		go s.pollReady(ctx, events)
+	}
+}
+
+// pollReady dials cfg.Address, a scheme-prefixed unix socket ("unix://...")
+// or Windows named pipe ("npipe://..." / `\\.\pipe\...`) address, until it
+// accepts connections, and emits a single EventReady, or gives up once ctx
+// is done. It is run once per EventStarted - Start fires it for the initial
+// process and monitor fires it again after each respawn - since a prior
+// instance's EventReady is stale once that process has exited and a new one
+// has taken its place.
+func (s *Supervisor) pollReady(ctx context.Context, events chan<- Event) {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			conn, err := dialAddress(ctx, s.cfg.Address)
+			if err != nil {
+				continue
+			}
+			conn.Close()
+			events <- Event{Type: EventReady}
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop sends signal to the supervised process and returns without waiting
+// for it to exit. It does not suspend RestartPolicy: the monitor goroutine
+// handles the resulting exit like any other and restarts the process if
+// the policy allows it.
+func (s *Supervisor) Stop(ctx context.Context, signal os.Signal) error {
+	s.mu.Lock()
+	cmd := s.cmd
+	s.mu.Unlock()
+	if cmd == nil {
+		return fmt.Errorf("supervisor: not running")
+	}
+	return cmd.Process.Signal(signal)
+}
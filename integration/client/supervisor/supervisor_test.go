@@ -0,0 +1,139 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package supervisor
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackoffForAttempt(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 10 * time.Second, Factor: 2}
+	attempts := []int{0, 1, 2, 3, 10}
+
+	got := []time.Duration{
+		b.forAttempt(0),
+		b.forAttempt(1),
+		b.forAttempt(2),
+		b.forAttempt(3),
+		b.forAttempt(10),
+	}
+	want := []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		10 * time.Second, // capped at Max
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("forAttempt(%d) = %v, want %v", attempts[i], got[i], want[i])
+		}
+	}
+}
+
+func TestBackoffForAttemptDefaults(t *testing.T) {
+	var b Backoff
+	if got := b.forAttempt(0); got != time.Second {
+		t.Errorf("zero-value Backoff.forAttempt(0) = %v, want %v", got, time.Second)
+	}
+}
+
+func TestConfigRenderConfigFileEmpty(t *testing.T) {
+	var c Config
+	path, err := c.renderConfigFile()
+	if err != nil {
+		t.Fatalf("renderConfigFile: %v", err)
+	}
+	if path != "" {
+		os.Remove(path)
+		t.Fatalf("renderConfigFile with no PluginConfigs returned %q, want empty", path)
+	}
+}
+
+// TestPollReadyFiresAfterRespawn verifies that a second EventReady is
+// emitted after RestartAlways respawns the process, not just for the
+// initial start.
+func TestPollReadyFiresAfterRespawn(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "test.sock")
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatalf("failed to bind fake daemon socket: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sv := &Supervisor{}
+	events, err := sv.Start(ctx, Config{
+		Binary:        "/bin/sh",
+		Args:          []string{"-c", "sleep 0.2"},
+		Address:       "unix://" + sockPath,
+		RestartPolicy: RestartAlways,
+		Backoff:       Backoff{Initial: 10 * time.Millisecond, Max: 10 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	ready := 0
+	for ready < 2 {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatalf("event channel closed after %d EventReady, want 2", ready)
+			}
+			if ev.Type == EventReady {
+				ready++
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out after %d EventReady, want 2", ready)
+		}
+	}
+}
+
+func TestConfigRenderConfigFile(t *testing.T) {
+	c := Config{PluginConfigs: map[string]interface{}{
+		"io.containerd.grpc.v1.cri": map[string]interface{}{
+			"sandbox_image": "registry.k8s.io/pause:3.9",
+		},
+	}}
+
+	path, err := c.renderConfigFile()
+	if err != nil {
+		t.Fatalf("renderConfigFile: %v", err)
+	}
+	defer os.Remove(path)
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("rendered config file missing: %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package supervisor
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+)
+
+// dialAddress dials a unix socket address, accepting both a bare path and
+// one prefixed with the "unix://" scheme.
+func dialAddress(ctx context.Context, address string) (io.Closer, error) {
+	addr := strings.TrimPrefix(address, "unix://")
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", addr)
+}